@@ -0,0 +1,38 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package checker
+
+// SegmentAnalyzer inspects the raw body of a fetched segment and reports protocol-level errors.
+// Implementations are stateful across calls so they can detect issues that only become visible
+// across multiple segments, such as continuity counter gaps. A Checker holds a single Analyzer;
+// swap it out to support other segment containers (e.g. fMP4) without changing CheckSegment.
+type SegmentAnalyzer interface {
+	// Analyze inspects a segment's body and returns the first problem it finds, or CheckOK.
+	Analyze(body []byte) CheckSegmentResult
+}
+
+// Resettable is implemented by SegmentAnalyzers that need to clear accumulated cross-segment state
+// when the playlist signals a discontinuity, since values such as continuity counters legitimately
+// restart there.
+type Resettable interface {
+	Reset()
+}
@@ -22,13 +22,25 @@
 package checker
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hlscheck/internal/httpclient"
+	"hlscheck/internal/metrics"
 	"hlscheck/plist"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 )
 
+// maxIdlePollInterval caps how long Run will wait between fetches of a playlist that has not
+// changed, even if its target duration is longer.
+const maxIdlePollInterval = 10 * time.Second
+
 type Checker struct {
 	// URL is the URL of the playlist to fetch.
 	URL string
@@ -42,6 +54,50 @@ type Checker struct {
 	ProtocolErrorCount uint64
 	// EmptySegmentErrorCount is the number of empty segment errors that occured while checking.
 	EmptySegmentErrorCount uint64
+	// CCErrorCount is the number of MPEG-TS continuity counter errors detected while checking.
+	CCErrorCount uint64
+	// NoVideoErrorCount is the number of segments that were missing video PES packets despite a known video PID.
+	NoVideoErrorCount uint64
+	// StaleErrorCount is the number of segments dropped without fetching because they had already
+	// gone stale by the time they were due to be checked.
+	StaleErrorCount uint64
+	// RedirectLoopErrorCount is the number of segment fetches that failed because the response
+	// followed more redirects than the redirect policy of Fetcher's client allows.
+	RedirectLoopErrorCount uint64
+	// Analyzer inspects the body of each fetched segment for protocol-level errors. Defaults to an
+	// MPEG-TS analyzer; set to nil to disable segment body analysis. Analyzer is stateful across
+	// segments (e.g. MPEG-TS continuity counters), so Run only ever calls it from a single
+	// goroutine, in media-sequence order; it must never be invoked concurrently.
+	Analyzer SegmentAnalyzer
+	// Fetcher performs the HTTP fetches for segment bodies. Share one Fetcher across every Checker
+	// spawned from the same master playlist to bound the total outbound connection count.
+	Fetcher *SegmentFetcher
+	// WorkerPool runs the concurrency-safe fetch stage of each segment check. Share one
+	// WorkerPool across every Checker spawned from the same master playlist so that an ABR ladder
+	// does not multiply the number of segments fetched concurrently by its rendition count. This
+	// is a deliberate substitution for a per-Checker Concurrency field: since the pool must be
+	// shared across variants to bound total concurrency, its size is configured once where the
+	// pool is created (the -concurrency flag, in StartPlaylistChecker) rather than per Checker.
+	WorkerPool *SegmentWorkerPool
+	// HTTPClient is used to fetch and re-fetch the variant playlist itself, and to fetch AES-128
+	// decryption keys. Share the same client passed to Fetcher so playlist, segment and key
+	// requests all honor the same TLS, header and redirect policy.
+	HTTPClient *http.Client
+	// Metrics reports this Checker's activity to Prometheus. Leave nil to disable metrics reporting.
+	Metrics *metrics.VariantMetrics
+
+	// targetDurationSec is the target duration learned from the last successfully parsed playlist,
+	// used to pace Run's polling interval.
+	targetDurationSec uint64
+	// lastPlaylistHash is the sha256 of the last fetched playlist body, so that Run can skip
+	// re-parsing a playlist that has not changed since the last fetch.
+	lastPlaylistHash [sha256.Size]byte
+	// mapURI, mapData and mapFetched cache the EXT-X-MAP init segment across polls. pollLoop parses
+	// into a fresh plist.Plist on every poll, so without this the cache plist.Parse keeps on that
+	// Plist would never carry over and the init segment would be re-fetched on every poll.
+	mapURI     string
+	mapData    []byte
+	mapFetched bool
 }
 
 type CheckSegmentResult uint
@@ -52,34 +108,172 @@ const (
 	CheckServerError
 	CheckProtocolError
 	CheckEmptySegmentError
+	// CheckCCError indicates a MPEG-TS continuity counter gap was detected in the segment.
+	CheckCCError
+	// CheckNoVideoError indicates the segment contained no PES start unit for the known video PID.
+	CheckNoVideoError
+	// CheckStaleError indicates the segment was dropped without fetching because it had already
+	// gone stale.
+	CheckStaleError
+	// CheckRedirectLoopError indicates the segment fetch followed more redirects than the redirect
+	// policy of the Fetcher's client allows.
+	CheckRedirectLoopError
 )
 
-// New creates a new instance of the HLS checker for a variant.
-func New(url string) Checker {
-	slog.Info("Starting HLS checker", "url", url)
+// New creates a new instance of the HLS checker for a variant. It performs no I/O; call Run with a
+// context to start checking. fetcher and workerPool are shared across every Checker spawned for
+// the same master playlist so that an ABR ladder does not multiply the outbound connection count,
+// or the number of segments fetched concurrently, by its rendition count; pass nil for either to
+// have the Checker create its own. httpClient is used to fetch the playlist itself and any
+// decryption keys; pass nil to fall back to a plain &http.Client{}.
+func New(ctx context.Context, rawURL string, fetcher *SegmentFetcher, workerPool *SegmentWorkerPool, httpClient *http.Client) (*Checker, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid playlist url: %v", err)
+	}
+	if fetcher == nil {
+		fetcher = NewSegmentFetcher(httpClient)
+	}
+	if workerPool == nil {
+		workerPool = NewSegmentWorkerPool(0)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	slog.InfoContext(ctx, "Created HLS checker", "url", rawURL)
+
+	return &Checker{
+		URL:        rawURL,
+		Analyzer:   NewMPEGTSAnalyzer(),
+		Fetcher:    fetcher,
+		WorkerPool: workerPool,
+		HTTPClient: httpClient,
+	}, nil
+}
+
+// segmentJob pairs a dispatched segment with the channel its fetch outcome will arrive on. Run's
+// ordered consumer goroutine ranges over a channel of these in dispatch order, so it waits for
+// each segment's outcome in media-sequence order even though WorkerPool may finish fetching them
+// out of order.
+type segmentJob struct {
+	seg     plist.Entry
+	outcome chan segmentOutcome
+}
+
+// segmentOutcome is the result of fetchSegmentBody: everything about a segment check that can
+// safely run concurrently, before the stateful analysis pass.
+type segmentOutcome struct {
+	bodyData []byte
+	result   CheckSegmentResult
+}
+
+// Run polls the playlist for new entries and checks their segments until ctx is cancelled. Each
+// new segment's fetch is handed to WorkerPool, which may run it concurrently with other segments
+// (including ones belonging to other Checkers); the stateful analysis of the fetched bodies is
+// then run in media-sequence order by a single goroutine owned by this Checker, so Analyzer is
+// never invoked concurrently with itself. The poll interval adapts to the manifest's target
+// duration, as recommended by the HLS specification, and backs off exponentially on fetch or parse
+// failures. Playlists whose body is byte-identical to the last fetch are not re-parsed.
+func (c *Checker) Run(ctx context.Context) error {
+	orderCh := make(chan segmentJob, 4)
+
+	var analysis sync.WaitGroup
+	analysis.Add(1)
+	go func() {
+		defer analysis.Done()
+		for job := range orderCh {
+			c.analyzeJob(job)
+		}
+	}()
+
+	err := c.pollLoop(ctx, orderCh)
+
+	close(orderCh)
+	analysis.Wait()
+
+	return err
+}
+
+// analyzeJob waits for job's fetch outcome, runs the stateful analysis pass over it if the fetch
+// succeeded, and records the final result. It must only ever be called from Run's single analysis
+// goroutine, in media-sequence order. A job whose fetch was never submitted to WorkerPool is
+// skipped without recording a result.
+func (c *Checker) analyzeJob(job segmentJob) {
+	outcome, ok := <-job.outcome
+	if !ok {
+		return
+	}
+
+	// A discontinuity means the stream can legitimately restart state such as PTS or continuity
+	// counters, so give the analyzer a chance to forget what it has learned before analyzing the
+	// segment that follows it.
+	if job.seg.Discontinuity {
+		if resettable, ok := c.Analyzer.(Resettable); ok {
+			resettable.Reset()
+		}
+	}
 
-	c := Checker{
-		URL: url,
+	result := outcome.result
+	if result == CheckOK && c.Analyzer != nil {
+		result = c.Analyzer.Analyze(outcome.bodyData)
 	}
-	go c.Loop()
 
-	return c
+	c.recordResult(job.seg, result)
 }
 
-// Loop will periodically check the playlist for new entries and check the segments.
-func (c *Checker) Loop() {
-	runTimer := time.NewTicker(time.Second)
-	defer runTimer.Stop()
+// pollLoop fetches and parses the playlist until ctx is cancelled, handing off every newly-seen
+// segment's fetch to WorkerPool and pushing its job onto orderCh in dispatch order.
+func (c *Checker) pollLoop(ctx context.Context, orderCh chan<- segmentJob) error {
+	var backoff time.Duration
 
 	for {
-		<-runTimer.C
+		body, err := plist.Fetch(c.URL, c.HTTPClient)
+		if err != nil {
+			slog.ErrorContext(ctx, "Fetching variant playlist failed", "url", c.URL, "err", err)
+			if !c.sleep(ctx, c.nextBackoff(&backoff)) {
+				return ctx.Err()
+			}
+			continue
+		}
 
-		pl := plist.Plist{}
-		if err := plist.FetchAndParse(&pl, c.URL); err != nil {
-			slog.Error("Fetching variant playlist failed", "url", c.URL)
+		if c.Metrics != nil {
+			c.Metrics.MarkPlaylistFetched()
+		}
+
+		bodyHash := sha256.Sum256([]byte(body))
+		if bodyHash == c.lastPlaylistHash {
+			backoff = 0
+			if !c.sleep(ctx, c.pollInterval(false)) {
+				return ctx.Err()
+			}
 			continue
 		}
 
+		// Seed the EXT-X-MAP cache from the last poll so a playlist that omits the tag on refresh
+		// (as the spec allows once the client already has it) doesn't trigger a re-fetch.
+		pl := plist.Plist{
+			MapURI:     c.mapURI,
+			MapData:    c.mapData,
+			MapFetched: c.mapFetched,
+		}
+		if err := plist.Parse(&pl, c.URL, body, c.HTTPClient); err != nil {
+			slog.ErrorContext(ctx, "Parsing variant playlist failed", "url", c.URL, "err", err)
+			if !c.sleep(ctx, c.nextBackoff(&backoff)) {
+				return ctx.Err()
+			}
+			continue
+		}
+		backoff = 0
+		c.lastPlaylistHash = bodyHash
+		c.targetDurationSec = pl.TargetDurationSec
+		c.mapURI = pl.MapURI
+		c.mapData = pl.MapData
+		c.mapFetched = pl.MapFetched
+		if c.Metrics != nil {
+			c.Metrics.SetTargetDuration(pl.TargetDurationSec)
+		}
+
+		sawNewSegment := false
 		for _, seg := range pl.Entries {
 			// Skip all segments that have already been checked.
 			if seg.MediaSequence <= c.CurrentMediaSequence {
@@ -87,25 +281,122 @@ func (c *Checker) Loop() {
 			}
 
 			c.CurrentMediaSequence = seg.MediaSequence
+			sawNewSegment = true
+
+			seg := seg
+			job := segmentJob{seg: seg, outcome: make(chan segmentOutcome, 1)}
+			select {
+			case orderCh <- job:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			if !c.WorkerPool.Submit(ctx, func() {
+				bodyData, result := c.retryFetchSegment(seg)
+				job.outcome <- segmentOutcome{bodyData: bodyData, result: result}
+			}) {
+				// The job is already queued on orderCh; close its outcome so analyzeJob skips it
+				// instead of waiting for a fetch that will never run.
+				close(job.outcome)
+				return ctx.Err()
+			}
+		}
+
+		if c.Metrics != nil {
+			c.Metrics.SetMediaSequence(c.CurrentMediaSequence)
+		}
+
+		// An EXT-X-ENDLIST tag means this is a complete VOD playlist: every segment has now been
+		// dispatched, so there is nothing left to poll for and the loop can stop cleanly.
+		if pl.Ended {
+			return nil
+		}
+
+		if !c.sleep(ctx, c.pollInterval(sawNewSegment)) {
+			return ctx.Err()
 		}
 	}
 }
 
-// RetryCheckSegment will try to fetch a segment three times before failing.
-func (c *Checker) RetryCheckSegment(seg plist.Entry) {
-	result := CheckOK
+// pollInterval computes how long Run should wait before its next fetch: half the target duration
+// if the last fetch produced new segments, or the full target duration (capped at
+// maxIdlePollInterval) if it did not.
+func (c *Checker) pollInterval(sawNewSegment bool) time.Duration {
+	target := time.Duration(c.targetDurationSec) * time.Second
+	if target == 0 {
+		target = time.Second
+	}
 
-	numRetries := 3
-	for numRetries > 0 {
-		result = c.CheckSegment(seg)
+	if sawNewSegment {
+		return target / 2
+	}
+	if target > maxIdlePollInterval {
+		return maxIdlePollInterval
+	}
+	return target
+}
+
+// nextBackoff advances backoff exponentially, starting at one second and capped at three times the
+// target duration (or 30 seconds if the target duration is not yet known), and returns the
+// duration to sleep for.
+func (c *Checker) nextBackoff(backoff *time.Duration) time.Duration {
+	maxBackoff := 3 * time.Duration(c.targetDurationSec) * time.Second
+	if maxBackoff == 0 {
+		maxBackoff = 30 * time.Second
+	}
+
+	if *backoff == 0 {
+		*backoff = time.Second
+	} else {
+		*backoff *= 2
+	}
+	if *backoff > maxBackoff {
+		*backoff = maxBackoff
+	}
+
+	return *backoff
+}
+
+// sleep waits for d or until ctx is cancelled, and reports whether it waited out the full duration.
+func (c *Checker) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryFetchSegment fetches and, if necessary, decrypts seg's body, making up to three attempts in
+// all before giving up. Segments that have already gone stale are dropped without being fetched at all. It
+// performs no stateful analysis, so unlike CheckSegment it is safe to call concurrently for
+// different segments of the same Checker; see Run, which dispatches it to a shared WorkerPool.
+func (c *Checker) retryFetchSegment(seg plist.Entry) ([]byte, CheckSegmentResult) {
+	if c.isStale(seg) {
+		return nil, CheckStaleError
+	}
+
+	var bodyData []byte
+	result := CheckOK
+	for numRetries := 3; numRetries > 0; numRetries-- {
+		bodyData, result = c.fetchSegmentBody(seg)
 		if result == CheckOK {
 			break
 		}
-
-		numRetries--
 		time.Sleep(250 * time.Millisecond)
 	}
 
+	return bodyData, result
+}
+
+// recordResult updates the Checker's error counters and metrics for a segment's final check
+// result, and logs anything other than success. Run only ever calls this from its single analysis
+// goroutine, so it needs no synchronization of its own despite segments being fetched
+// concurrently.
+func (c *Checker) recordResult(seg plist.Entry, result CheckSegmentResult) {
 	switch result {
 	case CheckClientError:
 		c.ClientErrorCount++
@@ -119,31 +410,124 @@ func (c *Checker) RetryCheckSegment(seg plist.Entry) {
 	case CheckEmptySegmentError:
 		c.EmptySegmentErrorCount++
 		slog.Error("Received empty segment", "url", seg.URL)
+	case CheckCCError:
+		c.CCErrorCount++
+		slog.Error("Continuity counter error while checking segment", "url", seg.URL)
+	case CheckNoVideoError:
+		c.NoVideoErrorCount++
+		slog.Error("Segment contained no video PES start unit", "url", seg.URL)
+	case CheckStaleError:
+		c.StaleErrorCount++
+		slog.Error("Dropped stale segment", "url", seg.URL)
+	case CheckRedirectLoopError:
+		c.RedirectLoopErrorCount++
+		slog.Error("Segment fetch exceeded the configured redirect limit", "url", seg.URL)
 	default:
 		break
 	}
+
+	if c.Metrics != nil {
+		c.Metrics.ObserveCheck(resultLabel(result))
+	}
 }
 
-// CheckSegment will try to fetch a segment by its URL
-func (c *Checker) CheckSegment(seg plist.Entry) CheckSegmentResult {
-	resp, err := http.Get(seg.URL)
+// resultLabel maps a CheckSegmentResult to the "result" label value used for the
+// hlscheck_segment_checks_total metric.
+func resultLabel(result CheckSegmentResult) string {
+	switch result {
+	case CheckOK:
+		return "ok"
+	case CheckClientError:
+		return "client"
+	case CheckServerError:
+		return "server"
+	case CheckProtocolError:
+		return "protocol"
+	case CheckEmptySegmentError:
+		return "empty"
+	case CheckCCError:
+		return "cc"
+	case CheckNoVideoError:
+		return "novideo"
+	case CheckStaleError:
+		return "stale"
+	case CheckRedirectLoopError:
+		return "redirect"
+	default:
+		return "unknown"
+	}
+}
+
+// isStale reports whether seg's staleness deadline has already passed. A segment is considered
+// stale once its program date time, plus its own duration, plus 3x the playlist's target
+// duration, is in the past; it is then too old for a check to still be useful. Segments without a
+// known program date time (no EXT-X-PROGRAM-DATE-TIME tag) are never considered stale.
+func (c *Checker) isStale(seg plist.Entry) bool {
+	if seg.ProgramDateTime.IsZero() {
+		return false
+	}
+
+	staleDeadline := seg.ProgramDateTime.
+		Add(time.Duration(seg.DurationSec * float64(time.Second))).
+		Add(3 * time.Duration(c.targetDurationSec) * time.Second)
+
+	return time.Now().After(staleDeadline)
+}
+
+// fetchSegmentBody downloads and, if necessary, decrypts a segment's body. It holds no state
+// across calls, so unlike Analyzer.Analyze it is safe to call concurrently for different segments
+// of the same Checker.
+func (c *Checker) fetchSegmentBody(seg plist.Entry) ([]byte, CheckSegmentResult) {
+	fetchStart := time.Now()
+	resp, err := c.Fetcher.Fetch(seg)
 	if err != nil {
-		return CheckProtocolError
+		if errors.Is(err, httpclient.ErrTooManyRedirects) {
+			return nil, CheckRedirectLoopError
+		}
+		return nil, CheckProtocolError
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode > 500 {
-		return CheckServerError
-	} else if resp.StatusCode > 400 {
-		return CheckClientError
+	if resp.StatusCode >= 500 {
+		return nil, CheckServerError
+	} else if resp.StatusCode >= 400 {
+		return nil, CheckClientError
 	}
 
 	bodyData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return CheckProtocolError
+		return nil, CheckProtocolError
+	}
+	if c.Metrics != nil {
+		c.Metrics.ObserveFetch(time.Since(fetchStart), len(bodyData))
 	}
 	if len(bodyData) == 0 {
-		return CheckEmptySegmentError
+		return nil, CheckEmptySegmentError
+	}
+
+	if seg.KeyMethod == "AES-128" {
+		bodyData, err = decryptSegment(seg, bodyData, c.HTTPClient)
+		if err != nil {
+			slog.Error("Decrypting segment failed", "url", seg.URL, "err", err)
+			return nil, CheckProtocolError
+		}
+	}
+
+	return bodyData, CheckOK
+}
+
+// CheckSegment fetches a segment by its URL and, if Analyzer is set, runs it through the stateful
+// segment analysis pass. It is not safe to call concurrently for segments of the same Checker:
+// Analyzer may depend on segments being analyzed in media-sequence order. Run never calls this
+// directly; it fetches via the concurrency-safe fetchSegmentBody and serializes analysis itself.
+func (c *Checker) CheckSegment(seg plist.Entry) CheckSegmentResult {
+	bodyData, result := c.fetchSegmentBody(seg)
+	if result != CheckOK {
+		return result
+	}
+
+	if c.Analyzer != nil {
+		return c.Analyzer.Analyze(bodyData)
 	}
 
 	return CheckOK
@@ -0,0 +1,100 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package checker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hlscheck/plist"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decryptSegment downloads the AES-128 key referenced by seg using client and decrypts body using
+// AES-128-CBC, as required for segments under an EXT-X-KEY tag with METHOD=AES-128.
+func decryptSegment(seg plist.Entry, body []byte, client *http.Client) ([]byte, error) {
+	keyResp, err := client.Get(seg.KeyURI)
+	if err != nil {
+		return nil, fmt.Errorf("fetching decryption key failed: %v", err)
+	}
+	defer keyResp.Body.Close()
+
+	key, err := io.ReadAll(keyResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching decryption key failed: could not read response body: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption key: %v", err)
+	}
+
+	iv, err := segmentIV(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 || len(body)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment is not a multiple of the AES block size")
+	}
+
+	decrypted := make([]byte, len(body))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, body)
+
+	return unpadPKCS7(decrypted)
+}
+
+// segmentIV resolves the initialization vector to use for seg. Per RFC 8216 section 5.2, if the
+// EXT-X-KEY tag did not carry an explicit IV attribute, the segment's media sequence number is
+// used as a big-endian 128-bit value instead.
+func segmentIV(seg plist.Entry) ([]byte, error) {
+	if seg.KeyIV == "" {
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], seg.MediaSequence)
+		return iv, nil
+	}
+
+	ivHex := strings.TrimPrefix(strings.TrimPrefix(seg.KeyIV, "0x"), "0X")
+	iv, err := hex.DecodeString(ivHex)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse IV attribute: %v", err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("IV attribute must be %d bytes, got %d", aes.BlockSize, len(iv))
+	}
+
+	return iv, nil
+}
+
+// unpadPKCS7 removes the PKCS#7 padding applied to AES-128-CBC encrypted segments.
+func unpadPKCS7(data []byte) ([]byte, error) {
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > aes.BlockSize || padLen > len(data) {
+		return nil, fmt.Errorf("invalid PKCS#7 padding")
+	}
+
+	return data[:len(data)-padLen], nil
+}
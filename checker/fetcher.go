@@ -0,0 +1,60 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package checker
+
+import (
+	"fmt"
+	"hlscheck/plist"
+	"net/http"
+)
+
+// SegmentFetcher performs the HTTP fetches for segment bodies on behalf of one or more Checkers.
+// Sharing a single SegmentFetcher (and its underlying *http.Client) across every Checker spawned
+// for an ABR ladder keeps the connection pool shared across variants instead of multiplying it by
+// the number of renditions.
+type SegmentFetcher struct {
+	Client *http.Client
+}
+
+// NewSegmentFetcher creates a SegmentFetcher backed by client. A nil client falls back to a plain
+// &http.Client{}.
+func NewSegmentFetcher(client *http.Client) *SegmentFetcher {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &SegmentFetcher{
+		Client: client,
+	}
+}
+
+// Fetch retrieves a segment's body, requesting only its byte range if seg.HasByteRange is set.
+func (f *SegmentFetcher) Fetch(seg plist.Entry) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, seg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if seg.HasByteRange {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.ByteRangeOffset, seg.ByteRangeOffset+seg.ByteRangeLength-1))
+	}
+
+	return f.Client.Do(req)
+}
@@ -0,0 +1,198 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package checker
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsPATPID     = 0x0000
+)
+
+// videoStreamTypes are the MPEG-TS stream_type values we treat as a video elementary stream when
+// resolving the PMT.
+var videoStreamTypes = map[byte]bool{
+	0x01: true, // MPEG-1 video
+	0x02: true, // MPEG-2 video
+	0x1b: true, // H.264 / AVC
+	0x24: true, // H.265 / HEVC
+}
+
+// MPEGTSAnalyzer is a SegmentAnalyzer for MPEG-TS segments. It resolves the PAT/PMT to learn the
+// video elementary stream PID, tracks the last continuity counter seen on every PID across
+// segments, and flags segments that never start a video PES packet once that PID is known.
+type MPEGTSAnalyzer struct {
+	pmtPID        uint16
+	pmtKnown      bool
+	videoPID      uint16
+	videoPIDKnown bool
+	lastCC        map[uint16]uint8
+}
+
+// NewMPEGTSAnalyzer creates a MPEGTSAnalyzer with no PAT/PMT state resolved yet.
+func NewMPEGTSAnalyzer() *MPEGTSAnalyzer {
+	return &MPEGTSAnalyzer{
+		lastCC: make(map[uint16]uint8),
+	}
+}
+
+// Analyze walks the 188-byte packets of a MPEG-TS segment, resolving the PAT/PMT, checking
+// continuity counters against the state learned from previous segments and verifying that the
+// known video PID produced at least one PES start unit.
+func (a *MPEGTSAnalyzer) Analyze(body []byte) CheckSegmentResult {
+	sawVideoPESStart := false
+	ccError := false
+
+	for offset := 0; offset+tsPacketSize <= len(body); offset += tsPacketSize {
+		pkt := body[offset : offset+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			continue
+		}
+
+		pusi := pkt[1]&0x40 != 0
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		adaptationFieldControl := (pkt[3] >> 4) & 0x03
+		cc := pkt[3] & 0x0f
+
+		payload := pkt[4:]
+		if adaptationFieldControl == 0x02 || adaptationFieldControl == 0x03 {
+			if len(payload) == 0 {
+				continue
+			}
+			afLen := int(payload[0])
+			if afLen+1 > len(payload) {
+				continue
+			}
+			payload = payload[afLen+1:]
+		}
+
+		hasPayload := adaptationFieldControl == 0x01 || adaptationFieldControl == 0x03
+		if hasPayload {
+			// A repeated continuity counter is the duplicate-packet exception, not a gap.
+			if lastCC, known := a.lastCC[pid]; known && cc != lastCC && cc != (lastCC+1)&0x0f {
+				ccError = true
+			}
+			a.lastCC[pid] = cc
+		}
+
+		if !hasPayload || len(payload) == 0 {
+			continue
+		}
+
+		switch pid {
+		case tsPATPID:
+			if pusi {
+				a.parsePAT(payload)
+			}
+		case a.pmtPID:
+			if a.pmtKnown && pusi {
+				a.parsePMT(payload)
+			}
+		case a.videoPID:
+			if a.videoPIDKnown && pusi {
+				sawVideoPESStart = true
+			}
+		}
+	}
+
+	if ccError {
+		return CheckCCError
+	}
+	if a.videoPIDKnown && !sawVideoPESStart {
+		return CheckNoVideoError
+	}
+	return CheckOK
+}
+
+// parsePAT parses a Program Association Table section and remembers the PMT PID of the first
+// program it finds.
+func (a *MPEGTSAnalyzer) parsePAT(payload []byte) {
+	section, ok := skipPSIPointerField(payload)
+	if !ok || len(section) < 8 || section[0] != 0x00 {
+		return
+	}
+
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+sectionLength > len(section) || sectionLength < 9 {
+		return
+	}
+	programLoop := section[8 : 3+sectionLength-4]
+
+	for i := 0; i+4 <= len(programLoop); i += 4 {
+		programNumber := uint16(programLoop[i])<<8 | uint16(programLoop[i+1])
+		pid := uint16(programLoop[i+2]&0x1f)<<8 | uint16(programLoop[i+3])
+		if programNumber != 0 {
+			a.pmtPID = pid
+			a.pmtKnown = true
+			return
+		}
+	}
+}
+
+// parsePMT parses a Program Map Table section and remembers the PID of the first video
+// elementary stream it finds.
+func (a *MPEGTSAnalyzer) parsePMT(payload []byte) {
+	section, ok := skipPSIPointerField(payload)
+	if !ok || len(section) < 12 || section[0] != 0x02 {
+		return
+	}
+
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+sectionLength > len(section) || sectionLength < 13 {
+		return
+	}
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	streamLoopStart := 12 + programInfoLength
+	streamLoopEnd := 3 + sectionLength - 4
+	if streamLoopStart > streamLoopEnd || streamLoopEnd > len(section) {
+		return
+	}
+
+	for i := streamLoopStart; i+5 <= streamLoopEnd; {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		esInfoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+		if videoStreamTypes[streamType] {
+			a.videoPID = pid
+			a.videoPIDKnown = true
+			return
+		}
+		i += 5 + esInfoLength
+	}
+}
+
+// Reset clears the continuity counter state accumulated across segments. Call this when the
+// playlist signals a discontinuity, since continuity counters legitimately restart there.
+func (a *MPEGTSAnalyzer) Reset() {
+	a.lastCC = make(map[uint16]uint8)
+}
+
+// skipPSIPointerField strips the pointer_field that precedes a new PSI section.
+func skipPSIPointerField(payload []byte) ([]byte, bool) {
+	if len(payload) == 0 {
+		return nil, false
+	}
+	pointerField := int(payload[0])
+	if 1+pointerField >= len(payload) {
+		return nil, false
+	}
+	return payload[1+pointerField:], true
+}
@@ -0,0 +1,67 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package checker
+
+import (
+	"context"
+	"runtime"
+)
+
+// SegmentWorkerPool is a fixed-size pool of goroutines shared across every Checker spawned for an
+// ABR ladder, so that the total number of segments being fetched concurrently is bounded across
+// all variants instead of being multiplied by the rendition count. It only ever runs the
+// concurrency-safe fetch stage of a segment check; each Checker is responsible for serializing the
+// stateful analysis of its own fetch results, see Run.
+type SegmentWorkerPool struct {
+	jobs chan func()
+}
+
+// NewSegmentWorkerPool creates a SegmentWorkerPool with workers goroutines. A workers value <= 0
+// falls back to runtime.NumCPU(). The pool runs for the lifetime of the process; its goroutines
+// simply exit when the program does, so there is no corresponding Close.
+func NewSegmentWorkerPool(workers int) *SegmentWorkerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	p := &SegmentWorkerPool{jobs: make(chan func())}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
+
+// Submit hands job to the next free worker, blocking until one is available or ctx is cancelled.
+// It reports whether job was submitted.
+func (p *SegmentWorkerPool) Submit(ctx context.Context, job func()) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
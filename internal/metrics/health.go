@@ -0,0 +1,84 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultStaleAfter is the staleness deadline used for a variant whose target duration is not yet
+// known.
+const defaultStaleAfter = 30 * time.Second
+
+// healthTracker records, per variant, when its playlist was last fetched successfully and how
+// stale that fetch is allowed to get before /healthz should report unhealthy.
+type healthTracker struct {
+	mu    sync.Mutex
+	state map[string]variantHealth
+}
+
+type variantHealth struct {
+	lastFetched       time.Time
+	targetDurationSec uint64
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{state: make(map[string]variantHealth)}
+}
+
+func (h *healthTracker) markFetched(variantURL string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.state[variantURL]
+	s.lastFetched = time.Now()
+	h.state[variantURL] = s
+}
+
+func (h *healthTracker) setTargetDuration(variantURL string, seconds uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.state[variantURL]
+	s.targetDurationSec = seconds
+	h.state[variantURL] = s
+}
+
+// Healthy reports whether every tracked variant's last successful playlist fetch is within 3x its
+// target duration.
+func (h *healthTracker) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.state {
+		maxAge := 3 * time.Duration(s.targetDurationSec) * time.Second
+		if maxAge == 0 {
+			maxAge = defaultStaleAfter
+		}
+		if time.Since(s.lastFetched) > maxAge {
+			return false
+		}
+	}
+
+	return true
+}
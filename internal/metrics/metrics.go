@@ -0,0 +1,131 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package metrics exposes hlscheck's checking activity as Prometheus collectors and a /healthz
+// endpoint, so that the tool can be wired into alerting instead of only emitting log lines.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registry holds every Prometheus collector hlscheck exposes, plus the health state needed to
+// answer /healthz. A single Registry is shared by every Checker in the process; use ForVariant to
+// get a handle bound to one variant's labels.
+type Registry struct {
+	registry *prometheus.Registry
+
+	segmentChecksTotal       *prometheus.CounterVec
+	segmentFetchDurationSecs *prometheus.HistogramVec
+	segmentBytes             *prometheus.HistogramVec
+	targetDurationSecs       *prometheus.GaugeVec
+	mediaSequence            *prometheus.GaugeVec
+
+	health *healthTracker
+}
+
+// New creates a Registry with every hlscheck collector registered.
+func New() *Registry {
+	r := &Registry{
+		registry: prometheus.NewRegistry(),
+		segmentChecksTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hlscheck_segment_checks_total",
+			Help: "Total number of segment checks, labeled by their result (ok, client, server, protocol, empty, cc, novideo, stale, redirect).",
+		}, []string{"variant_url", "bandwidth_bps", "result"}),
+		segmentFetchDurationSecs: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "hlscheck_segment_fetch_duration_seconds",
+			Help:    "Time taken to fetch a segment's body.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"variant_url", "bandwidth_bps"}),
+		segmentBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "hlscheck_segment_bytes",
+			Help:    "Size of fetched segment bodies in bytes.",
+			Buckets: prometheus.ExponentialBuckets(1024, 2, 12),
+		}, []string{"variant_url", "bandwidth_bps"}),
+		targetDurationSecs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "hlscheck_target_duration_seconds",
+			Help: "Current EXT-X-TARGETDURATION of the variant playlist.",
+		}, []string{"variant_url", "bandwidth_bps"}),
+		mediaSequence: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "hlscheck_media_sequence",
+			Help: "Current media sequence number checked for the variant playlist.",
+		}, []string{"variant_url", "bandwidth_bps"}),
+		health: newHealthTracker(),
+	}
+
+	r.registry.MustRegister(
+		r.segmentChecksTotal,
+		r.segmentFetchDurationSecs,
+		r.segmentBytes,
+		r.targetDurationSecs,
+		r.mediaSequence,
+	)
+
+	return r
+}
+
+// VariantMetrics is a Registry bound to one variant's labels. Hand one to each Checker so call
+// sites never need to repeat the variant URL or bandwidth.
+type VariantMetrics struct {
+	registry     *Registry
+	variantURL   string
+	bandwidthBps string
+}
+
+// ForVariant returns a VariantMetrics bound to variantURL and bandwidthBps.
+func (r *Registry) ForVariant(variantURL string, bandwidthBps uint64) *VariantMetrics {
+	return &VariantMetrics{
+		registry:     r,
+		variantURL:   variantURL,
+		bandwidthBps: strconv.FormatUint(bandwidthBps, 10),
+	}
+}
+
+// ObserveCheck records the result of a single segment check.
+func (v *VariantMetrics) ObserveCheck(result string) {
+	v.registry.segmentChecksTotal.WithLabelValues(v.variantURL, v.bandwidthBps, result).Inc()
+}
+
+// ObserveFetch records the duration and size of a segment fetch.
+func (v *VariantMetrics) ObserveFetch(duration time.Duration, bodyBytes int) {
+	v.registry.segmentFetchDurationSecs.WithLabelValues(v.variantURL, v.bandwidthBps).Observe(duration.Seconds())
+	v.registry.segmentBytes.WithLabelValues(v.variantURL, v.bandwidthBps).Observe(float64(bodyBytes))
+}
+
+// SetTargetDuration updates the variant's target duration gauge and the deadline /healthz judges
+// its playlist freshness against.
+func (v *VariantMetrics) SetTargetDuration(seconds uint64) {
+	v.registry.targetDurationSecs.WithLabelValues(v.variantURL, v.bandwidthBps).Set(float64(seconds))
+	v.registry.health.setTargetDuration(v.variantURL, seconds)
+}
+
+// SetMediaSequence updates the variant's current media sequence gauge.
+func (v *VariantMetrics) SetMediaSequence(seq uint64) {
+	v.registry.mediaSequence.WithLabelValues(v.variantURL, v.bandwidthBps).Set(float64(seq))
+}
+
+// MarkPlaylistFetched records that the variant's playlist was just fetched successfully.
+func (v *VariantMetrics) MarkPlaylistFetched() {
+	v.registry.health.markFetched(v.variantURL)
+}
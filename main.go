@@ -22,21 +22,61 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"hlscheck/checker"
+	"hlscheck/internal/httpclient"
+	"hlscheck/internal/metrics"
 	"hlscheck/plist"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// headerFlag collects repeated -header flags of the form "Key: Value" into a header map suitable
+// for httpclient.HTTPConfig.Headers.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	return ""
+}
+
+func (h headerFlag) Set(s string) error {
+	key, value, found := strings.Cut(s, ":")
+	if !found {
+		return fmt.Errorf("header %q must be in the form \"Key: Value\"", s)
+	}
+	h[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	return nil
+}
+
 func main() {
 	var streamUrl string
 	var logPath string
+	var concurrency int
+	var metricsAddr string
+	var caFile string
+	var insecure bool
+	var timeout time.Duration
+	var maxBodyBytes int64
+	headers := make(headerFlag)
 
 	flag.StringVar(&streamUrl, "url", "", "URL of the stream to check (to the master playlist)")
 	flag.StringVar(&logPath, "logfile", "", "Log file to redirect the output of the program to")
+	flag.IntVar(&concurrency, "concurrency", 0, "Total number of segments to check concurrently across every variant (defaults to the number of CPUs)")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":9090", "Address to serve Prometheus metrics and health checks on")
+	flag.Var(headers, "header", "Additional HTTP header to send with every request, as \"Key: Value\" (may be repeated)")
+	flag.StringVar(&caFile, "ca-file", "", "Path to a PEM-encoded CA certificate bundle to trust in addition to the system roots")
+	flag.BoolVar(&insecure, "insecure", false, "Disable TLS certificate verification")
+	flag.DurationVar(&timeout, "timeout", 0, "Timeout for each HTTP request, or 0 for no timeout")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", 0, "Maximum number of bytes to read from a single response body, or 0 for no limit")
 	flag.Parse()
 
 	if streamUrl == "" {
@@ -47,12 +87,41 @@ func main() {
 		SetupLogfile(logPath)
 	}
 
-	StartPlaylistChecker(streamUrl)
+	httpClient, err := httpclient.NewHTTPClient(httpclient.HTTPConfig{
+		Timeout:            timeout,
+		InsecureSkipVerify: insecure,
+		CAFile:             caFile,
+		Headers:            headers,
+		MaxBodyBytes:       maxBodyBytes,
+	})
+	if err != nil {
+		slog.Error("Building HTTP client failed", "err", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	metricsRegistry := metrics.New()
+	go func() {
+		if err := metricsRegistry.Serve(ctx, metricsAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped unexpectedly", "err", err)
+		}
+	}()
+
+	wg, err := StartPlaylistChecker(ctx, streamUrl, concurrency, metricsRegistry, httpClient)
+	if err != nil {
+		slog.Error("Starting playlist checker failed", "err", err)
+		cancel()
+		os.Exit(1)
+	}
 
 	// Wait for a SIGINT or SIGTERM signal to stop the application.
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	<-signals
+
+	cancel()
+	wg.Wait()
 }
 
 // SetupLogfile will add a new slog handler to write the log output to file.
@@ -67,20 +136,51 @@ func SetupLogfile(logPath string) {
 	slog.SetDefault(logHandle)
 }
 
-// StartPlaylistChecker will fetch the user-provided URL and start a checker instance for each variant playlist.
-func StartPlaylistChecker(url string) {
+// StartPlaylistChecker will fetch the user-provided URL and start a checker instance for each
+// variant playlist, running each in its own goroutine until ctx is cancelled. Every checker shares
+// a single SegmentFetcher, SegmentWorkerPool and httpClient, so a multi-rendition ABR ladder does
+// not multiply the outbound connection count, or the number of segments fetched concurrently, by
+// its number of variants. The returned WaitGroup is done once every checker goroutine has stopped.
+func StartPlaylistChecker(ctx context.Context, url string, concurrency int, metricsRegistry *metrics.Registry, httpClient *http.Client) (*sync.WaitGroup, error) {
 	pl := plist.Plist{}
-	if err := plist.FetchAndParse(&pl, url); err != nil {
-		slog.Error("Fetching playlist failed", "err", err)
-		os.Exit(1)
+	if err := plist.FetchAndParse(&pl, url, httpClient); err != nil {
+		return nil, err
+	}
+
+	fetcher := checker.NewSegmentFetcher(httpClient)
+	workerPool := checker.NewSegmentWorkerPool(concurrency)
+
+	var wg sync.WaitGroup
+	runChecker := func(variantUrl string, bandwidthBps uint64) error {
+		c, err := checker.New(ctx, variantUrl, fetcher, workerPool, httpClient)
+		if err != nil {
+			return err
+		}
+		c.Metrics = metricsRegistry.ForVariant(variantUrl, bandwidthBps)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Checker stopped unexpectedly", "url", variantUrl, "err", err)
+			}
+		}()
+
+		return nil
 	}
 
 	switch pl.Type {
 	case plist.MasterPlist:
 		for _, plEntry := range pl.Entries {
-			checker.New(plEntry.URL)
+			if err := runChecker(plEntry.URL, plEntry.BandwidthBps); err != nil {
+				return nil, err
+			}
 		}
 	case plist.VariantPlist:
-		checker.New(url)
+		if err := runChecker(url, 0); err != nil {
+			return nil, err
+		}
 	}
+
+	return &wg, nil
 }
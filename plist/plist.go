@@ -24,11 +24,13 @@ package plist
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Type int
@@ -51,6 +53,24 @@ type Entry struct {
 	ExtraInfo string
 	// URL is the absolute URL of the referenced segment or playlist.
 	URL string
+	// Discontinuity is true if an EXT-X-DISCONTINUITY tag directly precedes this segment.
+	Discontinuity bool
+	// DiscontinuitySequence is the discontinuity sequence number in effect for this segment.
+	DiscontinuitySequence uint64
+	// HasByteRange is true if this segment was declared with an EXT-X-BYTERANGE tag.
+	HasByteRange bool
+	// ByteRangeLength is the number of bytes to fetch, only valid if HasByteRange is true.
+	ByteRangeLength int64
+	// ByteRangeOffset is the offset of the first byte to fetch, only valid if HasByteRange is true.
+	ByteRangeOffset int64
+	// KeyMethod is the EXT-X-KEY encryption method in effect for this segment, e.g. "AES-128", or empty if unencrypted.
+	KeyMethod string
+	// KeyURI is the URI to fetch the decryption key from, only valid if KeyMethod is set.
+	KeyURI string
+	// KeyIV is the explicit initialization vector from the EXT-X-KEY tag, or empty if it should be derived from MediaSequence.
+	KeyIV string
+	// ProgramDateTime is the wall-clock time of the first sample in the segment, from EXT-X-PROGRAM-DATE-TIME.
+	ProgramDateTime time.Time
 }
 
 type Plist struct {
@@ -62,6 +82,69 @@ type Plist struct {
 	CurrentMediaSequence uint64
 	// TargetDurationSec is the target duration of each segment.
 	TargetDurationSec uint64
+	// DiscontinuitySequence is the current discontinuity sequence number, incremented by every EXT-X-DISCONTINUITY tag.
+	DiscontinuitySequence uint64
+	// Ended is true once an EXT-X-ENDLIST tag has been seen, indicating this is a complete VOD playlist.
+	Ended bool
+	// MapURI is the absolute URL of the fMP4 init segment referenced by the last EXT-X-MAP tag, if any.
+	MapURI string
+	// MapData is the body of the fMP4 init segment referenced by MapURI, fetched once and cached here.
+	MapData []byte
+	// MapFetched is true once MapData has been fetched for the current MapURI.
+	MapFetched bool
+
+	// currentKeyMethod, currentKeyURI and currentKeyIV hold the EXT-X-KEY state applied to segments
+	// encountered until the next EXT-X-KEY tag changes it.
+	currentKeyMethod string
+	currentKeyURI    string
+	currentKeyIV     string
+	// lastByteRangeEnd is the byte offset immediately after the last parsed EXT-X-BYTERANGE, used to
+	// resolve the offset of a following EXT-X-BYTERANGE tag that omits it.
+	lastByteRangeEnd int64
+}
+
+// parseAttributeList tokenizes a HLS attribute list of "KEY=VALUE" pairs, honoring quoted string
+// values that may themselves contain commas (e.g. CODECS="avc1.64001f,mp4a.40.2").
+func parseAttributeList(s string) (map[string]string, error) {
+	attrs := make(map[string]string)
+
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("malformed attribute: missing '='")
+		}
+		name := strings.TrimSpace(s[:eq])
+		rest := s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("malformed attribute: unterminated quoted value")
+			}
+			value = rest[1 : 1+end]
+			rest = strings.TrimPrefix(rest[1+end+1:], ",")
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value = rest[:comma]
+			rest = rest[comma+1:]
+		} else {
+			value = rest
+			rest = ""
+		}
+
+		attrs[name] = value
+		s = rest
+	}
+
+	return attrs, nil
+}
+
+// resolveUrl turns a URI found in a tag into an absolute URL relative to baseUrl.
+func resolveUrl(baseUrl string, uriStr string) (string, error) {
+	if strings.HasPrefix(uriStr, "http") {
+		return uriStr, nil
+	}
+	return url.JoinPath(baseUrl, uriStr)
 }
 
 // parseStreamInfTag will parse an #EXT-X-STREAM-INF tag.
@@ -70,33 +153,24 @@ func parseStreamInfTag(e *Entry, tag string) error {
 	if !attrListStrValid {
 		return fmt.Errorf("malformed EXT-X-STREAM-INF tag")
 	}
-	attrList := strings.Split(attrListStr, ",")
-
-	bandwidthPresent := false
-	for _, attr := range attrList {
-		attrSplit := strings.Split(attr, "=")
-		if len(attrSplit) < 2 {
-			return fmt.Errorf("malformed attribute in EXT-X-STREAM-INF tag")
-		}
-
-		attrName := attrSplit[0]
-		attrValue := strings.Join(attrSplit[1:], "=")
-		switch attrName {
-		case "BANDWIDTH":
-			bandwidthPresent = true
-			bandwidth, err := strconv.ParseUint(attrValue, 10, 64)
-			if err != nil {
-				return fmt.Errorf("unable to parse bandwidth attribute in EXT-X-STREAM-INF tag")
-			}
-			e.BandwidthBps = bandwidth
-		case "CODECS":
-			e.Codecs = strings.Trim(attrValue, "\" ")
-		}
+	attrs, err := parseAttributeList(attrListStr)
+	if err != nil {
+		return fmt.Errorf("malformed EXT-X-STREAM-INF tag: %v", err)
 	}
 
+	bandwidthStr, bandwidthPresent := attrs["BANDWIDTH"]
 	if !bandwidthPresent {
 		return fmt.Errorf("missing bandwidth attribute in EXT-X-STREAM-INF tag")
 	}
+	bandwidth, err := strconv.ParseUint(bandwidthStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("unable to parse bandwidth attribute in EXT-X-STREAM-INF tag")
+	}
+	e.BandwidthBps = bandwidth
+
+	if codecs, ok := attrs["CODECS"]; ok {
+		e.Codecs = codecs
+	}
 
 	return nil
 }
@@ -160,8 +234,154 @@ func parseTargetDurationTag(pl *Plist, tag string) error {
 	return nil
 }
 
-// Parse will parse a HLS M3U8 playlist from a string.
-func Parse(pl *Plist, plUrlStr string, str string) error {
+// parseDiscontinuitySequenceTag will parse an EXT-X-DISCONTINUITY-SEQUENCE tag
+func parseDiscontinuitySequenceTag(pl *Plist, tag string) error {
+	tagValue, tagValid := strings.CutPrefix(tag, "EXT-X-DISCONTINUITY-SEQUENCE:")
+	if !tagValid {
+		return fmt.Errorf("malformed EXT-X-DISCONTINUITY-SEQUENCE tag")
+	}
+
+	discontinuitySequence, err := strconv.ParseUint(tagValue, 10, 64)
+	if err != nil {
+		return fmt.Errorf("unable to parse discontinuity sequence from EXT-X-DISCONTINUITY-SEQUENCE tag")
+	}
+
+	pl.DiscontinuitySequence = discontinuitySequence
+
+	return nil
+}
+
+// parseByteRangeTag will parse an EXT-X-BYTERANGE tag, which applies to the following segment entry.
+// If the tag omits the offset, it continues from the end of the last parsed byte range.
+func parseByteRangeTag(pl *Plist, e *Entry, tag string) error {
+	tagValue, tagValid := strings.CutPrefix(tag, "EXT-X-BYTERANGE:")
+	if !tagValid {
+		return fmt.Errorf("malformed EXT-X-BYTERANGE tag")
+	}
+
+	parts := strings.SplitN(tagValue, "@", 2)
+	length, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unable to parse length from EXT-X-BYTERANGE tag")
+	}
+
+	offset := pl.lastByteRangeEnd
+	if len(parts) == 2 {
+		offset, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unable to parse offset from EXT-X-BYTERANGE tag")
+		}
+	}
+
+	e.HasByteRange = true
+	e.ByteRangeLength = length
+	e.ByteRangeOffset = offset
+	pl.lastByteRangeEnd = offset + length
+
+	return nil
+}
+
+// parseProgramDateTimeTag will parse an EXT-X-PROGRAM-DATE-TIME tag.
+func parseProgramDateTimeTag(e *Entry, tag string) error {
+	tagValue, tagValid := strings.CutPrefix(tag, "EXT-X-PROGRAM-DATE-TIME:")
+	if !tagValid {
+		return fmt.Errorf("malformed EXT-X-PROGRAM-DATE-TIME tag")
+	}
+
+	programDateTime, err := time.Parse(time.RFC3339Nano, tagValue)
+	if err != nil {
+		return fmt.Errorf("unable to parse timestamp from EXT-X-PROGRAM-DATE-TIME tag: %v", err)
+	}
+	e.ProgramDateTime = programDateTime
+
+	return nil
+}
+
+// parseKeyTag will parse an EXT-X-KEY tag, updating the encryption state applied to segments
+// encountered until the next EXT-X-KEY tag.
+func parseKeyTag(pl *Plist, tag string) error {
+	attrListStr, attrListStrValid := strings.CutPrefix(tag, "EXT-X-KEY:")
+	if !attrListStrValid {
+		return fmt.Errorf("malformed EXT-X-KEY tag")
+	}
+	attrs, err := parseAttributeList(attrListStr)
+	if err != nil {
+		return fmt.Errorf("malformed EXT-X-KEY tag: %v", err)
+	}
+
+	method, methodPresent := attrs["METHOD"]
+	if !methodPresent {
+		return fmt.Errorf("missing method attribute in EXT-X-KEY tag")
+	}
+
+	pl.currentKeyMethod = method
+	if method == "NONE" {
+		pl.currentKeyURI = ""
+		pl.currentKeyIV = ""
+		return nil
+	}
+
+	pl.currentKeyURI = attrs["URI"]
+	pl.currentKeyIV = attrs["IV"]
+
+	return nil
+}
+
+// parseMapTag will parse an EXT-X-MAP tag and, unless its init segment has already been fetched,
+// try to download it once using client and remember it on the Plist. A nil client falls back to
+// http.DefaultClient. The init segment fetch is best-effort: a transient or unreachable init URL
+// is logged and otherwise ignored rather than failing the whole Parse, since the playlist itself
+// may still be perfectly checkable. MapURI, MapData and MapFetched are then left unchanged, so a
+// later Parse call that meets the same tag tries the fetch again.
+func parseMapTag(pl *Plist, baseUrl string, tag string, client *http.Client) error {
+	attrListStr, attrListStrValid := strings.CutPrefix(tag, "EXT-X-MAP:")
+	if !attrListStrValid {
+		return fmt.Errorf("malformed EXT-X-MAP tag")
+	}
+	attrs, err := parseAttributeList(attrListStr)
+	if err != nil {
+		return fmt.Errorf("malformed EXT-X-MAP tag: %v", err)
+	}
+
+	uriStr, uriPresent := attrs["URI"]
+	if !uriPresent {
+		return fmt.Errorf("missing uri attribute in EXT-X-MAP tag")
+	}
+	mapUrl, err := resolveUrl(baseUrl, uriStr)
+	if err != nil {
+		return fmt.Errorf("unable to resolve url in EXT-X-MAP tag: %v", err)
+	}
+
+	if pl.MapFetched && pl.MapURI == mapUrl {
+		return nil
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(mapUrl)
+	if err != nil {
+		slog.Warn("Fetching EXT-X-MAP init segment failed, will retry on the next poll", "url", mapUrl, "err", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	mapData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("Fetching EXT-X-MAP init segment failed, will retry on the next poll", "url", mapUrl, "err", err)
+		return nil
+	}
+
+	pl.MapURI = mapUrl
+	pl.MapData = mapData
+	pl.MapFetched = true
+
+	return nil
+}
+
+// Parse will parse a HLS M3U8 playlist from a string. client is used to fetch any EXT-X-MAP init
+// segment encountered; a nil client falls back to http.DefaultClient.
+func Parse(pl *Plist, plUrlStr string, str string, client *http.Client) error {
 	plUrl, err := url.Parse(plUrlStr)
 	if err != nil {
 		return fmt.Errorf("failed to parse playlist url: %v", err)
@@ -171,6 +391,7 @@ func Parse(pl *Plist, plUrlStr string, str string) error {
 	baseUrl := plUrl.String()
 
 	isExtM3U := false
+	pendingDiscontinuity := false
 	currentEntry := Entry{}
 	for lineIdx, line := range strings.Split(str, "\n") {
 		if strings.HasPrefix(line, "#") {
@@ -195,8 +416,9 @@ func Parse(pl *Plist, plUrlStr string, str string) error {
 				}
 			} else if strings.HasPrefix(extTag, "EXTINF") {
 				// A EXTINF tag indicates this is a variant playlist and that a segment entry will follow.
+				// currentEntry is intentionally not reset here: EXT-X-BYTERANGE and
+				// EXT-X-PROGRAM-DATE-TIME tags may precede EXTINF and must not be discarded.
 				pl.Type = VariantPlist
-				currentEntry = Entry{}
 
 				if err = parseInfTag(&currentEntry, extTag); err != nil {
 					return fmt.Errorf("line %d: %v", lineIdx, err)
@@ -211,6 +433,33 @@ func Parse(pl *Plist, plUrlStr string, str string) error {
 				if err = parseTargetDurationTag(pl, extTag); err != nil {
 					return fmt.Errorf("line %d: %v", lineIdx, err)
 				}
+			} else if strings.HasPrefix(extTag, "EXT-X-DISCONTINUITY-SEQUENCE") {
+				if err = parseDiscontinuitySequenceTag(pl, extTag); err != nil {
+					return fmt.Errorf("line %d: %v", lineIdx, err)
+				}
+			} else if strings.HasPrefix(extTag, "EXT-X-DISCONTINUITY") {
+				// Surface the discontinuity to the next segment entry so checkers can reset any
+				// state that assumes a continuous stream (PTS, continuity counters, ...).
+				pl.DiscontinuitySequence++
+				pendingDiscontinuity = true
+			} else if strings.HasPrefix(extTag, "EXT-X-BYTERANGE") {
+				if err = parseByteRangeTag(pl, &currentEntry, extTag); err != nil {
+					return fmt.Errorf("line %d: %v", lineIdx, err)
+				}
+			} else if strings.HasPrefix(extTag, "EXT-X-KEY") {
+				if err = parseKeyTag(pl, extTag); err != nil {
+					return fmt.Errorf("line %d: %v", lineIdx, err)
+				}
+			} else if strings.HasPrefix(extTag, "EXT-X-MAP") {
+				if err = parseMapTag(pl, baseUrl, extTag, client); err != nil {
+					return fmt.Errorf("line %d: %v", lineIdx, err)
+				}
+			} else if strings.HasPrefix(extTag, "EXT-X-PROGRAM-DATE-TIME") {
+				if err = parseProgramDateTimeTag(&currentEntry, extTag); err != nil {
+					return fmt.Errorf("line %d: %v", lineIdx, err)
+				}
+			} else if strings.HasPrefix(extTag, "EXT-X-ENDLIST") {
+				pl.Ended = true
 			}
 
 			continue
@@ -222,19 +471,23 @@ func Parse(pl *Plist, plUrlStr string, str string) error {
 		}
 
 		// Lines that do not start with http(s) are links relative to the playlist URL.
-		if strings.HasPrefix(line, "http") {
-			currentEntry.URL = line
-		} else {
-			currentEntry.URL, err = url.JoinPath(baseUrl, line)
-			if err != nil {
-				return fmt.Errorf("line %d: unable to join url: %v", lineIdx, err)
-			}
+		currentEntry.URL, err = resolveUrl(baseUrl, line)
+		if err != nil {
+			return fmt.Errorf("line %d: unable to join url: %v", lineIdx, err)
 		}
 
 		// Store the media sequence of the segment.
 		currentEntry.MediaSequence = pl.CurrentMediaSequence
 		pl.CurrentMediaSequence += 1
 
+		// Fold in the discontinuity and encryption state accumulated since the last segment.
+		currentEntry.Discontinuity = pendingDiscontinuity
+		currentEntry.DiscontinuitySequence = pl.DiscontinuitySequence
+		pendingDiscontinuity = false
+		currentEntry.KeyMethod = pl.currentKeyMethod
+		currentEntry.KeyURI = pl.currentKeyURI
+		currentEntry.KeyIV = pl.currentKeyIV
+
 		// Then, append it to the list of entries.
 		pl.Entries = append(pl.Entries, currentEntry)
 		currentEntry = Entry{}
@@ -247,18 +500,35 @@ func Parse(pl *Plist, plUrlStr string, str string) error {
 	return nil
 }
 
-// FetchAndParse will fetch a playlist using HTTP and try to parse it.
-func FetchAndParse(pl *Plist, url string) error {
-	resp, err := http.Get(url)
+// Fetch downloads a playlist body over HTTP without parsing it, so that callers can decide whether
+// the body actually needs parsing (e.g. by comparing it against a previously fetched one). A nil
+// client falls back to http.DefaultClient.
+func Fetch(url string, client *http.Client) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(url)
 	if err != nil {
-		return fmt.Errorf("fetching playlist failed: %v", err)
+		return "", fmt.Errorf("fetching playlist failed: %v", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("fetching playlist failed: could not read response body: %v", err)
+		return "", fmt.Errorf("fetching playlist failed: could not read response body: %v", err)
+	}
+
+	return string(respBody), nil
+}
+
+// FetchAndParse will fetch a playlist using client and try to parse it. A nil client falls back to
+// http.DefaultClient.
+func FetchAndParse(pl *Plist, url string, client *http.Client) error {
+	body, err := Fetch(url, client)
+	if err != nil {
+		return err
 	}
 
-	return Parse(pl, url, string(respBody))
+	return Parse(pl, url, body, client)
 }
@@ -0,0 +1,159 @@
+/*
+	Copyright 2025 Jan Blaesi
+
+	Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+	and associated documentation files (the “Software”), to deal in the Software without
+	restriction, including without limitation the rights to use, copy, modify, merge, publish,
+	distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in all copies or
+	substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+	THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+	OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+	OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package plist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestParseAttributeListQuotedComma covers the motivating bug fix: a naive split on "," mis-parses
+// a quoted attribute value that itself contains commas, such as CODECS in the RFC 8216 appendix.
+func TestParseAttributeListQuotedComma(t *testing.T) {
+	attrs, err := parseAttributeList(`BANDWIDTH=1280000,CODECS="avc1.64001f,mp4a.40.2"`)
+	if err != nil {
+		t.Fatalf("parseAttributeList returned error: %v", err)
+	}
+
+	if attrs["BANDWIDTH"] != "1280000" {
+		t.Errorf("expected BANDWIDTH=1280000, got %q", attrs["BANDWIDTH"])
+	}
+	if attrs["CODECS"] != "avc1.64001f,mp4a.40.2" {
+		t.Errorf("expected CODECS to keep its embedded comma, got %q", attrs["CODECS"])
+	}
+}
+
+// TestParseMasterPlaylist is based on the basic variant stream example from the RFC 8216 appendix.
+func TestParseMasterPlaylist(t *testing.T) {
+	const playlist = "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS=\"avc1.64001f,mp4a.40.2\"\n" +
+		"http://example.com/low/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2560000,CODECS=\"avc1.64001f,mp4a.40.2\"\n" +
+		"http://example.com/mid/index.m3u8\n"
+
+	pl := Plist{}
+	if err := Parse(&pl, "http://example.com/master.m3u8", playlist, nil); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if pl.Type != MasterPlist {
+		t.Fatalf("expected MasterPlist, got %v", pl.Type)
+	}
+	if len(pl.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(pl.Entries))
+	}
+	if pl.Entries[0].BandwidthBps != 1280000 {
+		t.Errorf("expected first entry bandwidth 1280000, got %d", pl.Entries[0].BandwidthBps)
+	}
+	if pl.Entries[0].Codecs != "avc1.64001f,mp4a.40.2" {
+		t.Errorf("expected first entry codecs to survive the embedded comma, got %q", pl.Entries[0].Codecs)
+	}
+}
+
+// TestParseVariantPlaylistTags is based on the RFC 8216 appendix examples for byte ranges, program
+// date time, discontinuities and key material.
+func TestParseVariantPlaylistTags(t *testing.T) {
+	const playlist = "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:10\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://priv.example.com/key.bin\",IV=0x00000000000000000000000000000001\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2010-02-19T14:54:23.031+08:00\n" +
+		"#EXT-X-BYTERANGE:75232@0\n" +
+		"#EXTINF:10,\n" +
+		"http://media.example.com/segment1.ts\n" +
+		"#EXT-X-BYTERANGE:82112\n" +
+		"#EXTINF:10,\n" +
+		"http://media.example.com/segment2.ts\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:10,\n" +
+		"http://media.example.com/segment3.ts\n" +
+		"#EXT-X-ENDLIST\n"
+
+	pl := Plist{}
+	if err := Parse(&pl, "http://media.example.com/playlist.m3u8", playlist, nil); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if !pl.Ended {
+		t.Errorf("expected EXT-X-ENDLIST to set Ended")
+	}
+	if len(pl.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(pl.Entries))
+	}
+
+	first := pl.Entries[0]
+	if first.KeyMethod != "AES-128" || first.KeyURI != "https://priv.example.com/key.bin" {
+		t.Errorf("expected first entry to carry the AES-128 key, got method=%q uri=%q", first.KeyMethod, first.KeyURI)
+	}
+	if first.ProgramDateTime.IsZero() {
+		t.Errorf("expected first entry to carry a program date time")
+	}
+	if !first.HasByteRange || first.ByteRangeLength != 75232 || first.ByteRangeOffset != 0 {
+		t.Errorf("expected first entry byte range 75232@0, got length=%d offset=%d", first.ByteRangeLength, first.ByteRangeOffset)
+	}
+
+	second := pl.Entries[1]
+	if !second.HasByteRange || second.ByteRangeLength != 82112 || second.ByteRangeOffset != 75232 {
+		t.Errorf("expected second entry byte range to continue from the first, got length=%d offset=%d", second.ByteRangeLength, second.ByteRangeOffset)
+	}
+
+	third := pl.Entries[2]
+	if !third.Discontinuity {
+		t.Errorf("expected third entry to be marked as a discontinuity")
+	}
+	if third.DiscontinuitySequence != 1 {
+		t.Errorf("expected third entry discontinuity sequence 1, got %d", third.DiscontinuitySequence)
+	}
+}
+
+// TestParseMapTagFetchesOnce verifies that an EXT-X-MAP init segment is downloaded and cached on
+// the Plist, and is not re-fetched for a second segment referencing the same URI.
+func TestParseMapTagFetchesOnce(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write([]byte("init-segment-data"))
+	}))
+	defer server.Close()
+
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:10\n" +
+		"#EXT-X-MAP:URI=\"" + server.URL + "/init.mp4\"\n" +
+		"#EXTINF:10,\n" +
+		"segment1.m4s\n" +
+		"#EXT-X-MAP:URI=\"" + server.URL + "/init.mp4\"\n" +
+		"#EXTINF:10,\n" +
+		"segment2.m4s\n"
+
+	pl := Plist{}
+	if err := Parse(&pl, server.URL+"/playlist.m3u8", playlist, nil); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected the init segment to be fetched once, got %d fetches", fetches)
+	}
+	if string(pl.MapData) != "init-segment-data" {
+		t.Errorf("expected MapData to hold the fetched init segment, got %q", pl.MapData)
+	}
+}